@@ -3,20 +3,72 @@ package main
 import (
     "fmt"
     "flag"
+    "errors"
     "os"
     "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "coords"
     "gmapinfo"
 )
 
+// Subcommand: "gmapinfo <name> [flags] <args...>"
+type command struct {
+    args string // Arguments synopsis for usage message
+    run  func(flags *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]*command{
+    "locate":           {"[flags] <img-file> [<lat> <lon>]", runLocate},
+    "coverage":         {"[flags] <output-file> <img-file>...", runCoverage},
+    "export geojson":   {"[flags] <img-file> <output-file>", runExportGeoJSON},
+    "export gpx":       {"[flags] <img-file> <output-file>", runExportGpx},
+    "export mp":        {"[flags] <img-file> <output-dir>|<output-file>", runExportMp},
+    "export osm":       {"[flags] <img-file> <output-file>", runExportOsm},
+    "export pmtiles":   {"[flags] <img-file> <output-file>", runExportPmtiles},
+    "export shapefile": {"[flags] <img-file> <output-basename>", runExportShapefile},
+    "grep":             {"[flags] <img-file> <pattern>", runGrep},
+    "places":           {"[flags] <img-file>", runPlaces},
+    "pois":             {"[flags] <img-file> [<csv-file>]", runPois},
+    "render png":       {"[flags] <img-file> <output-file>", runRenderPng},
+    "render svg":       {"[flags] <img-file> <output-file>", runRenderSvg},
+    "roads":            {"[flags] <img-file> [<csv-file>]", runRoads},
+    "serve-tiles":      {"[flags] <img-file>...", runServeTiles},
+    "srt":              {"[flags] <img-file>", runSrt},
+    "stats routing":    {"[flags] <img-file> [<json-file>]", runStatsRouting},
+    "stats types":      {"[flags] <img-file> [<json-file>]", runStatsTypes},
+    "streets":          {"[flags] <img-file> [<csv-file>]", runStreets},
+    "types":            {"[flags] [<img-file>]", runTypes},
+}
+
+var errBadArguments = errors.New("bad arguments")
+
 func main() {
     fmt.Println("gmapinfo 1.0.0")
 
+    if len(os.Args) > 2 {
+        // Some commands consist of two words, like "export geojson"
+        name := os.Args[1] + " " + os.Args[2]
+        if cmd, ok := commands[name]; ok {
+            runCommand(name, cmd, os.Args[3:])
+            return
+        }
+    }
+    if len(os.Args) > 1 {
+        if cmd, ok := commands[os.Args[1]]; ok {
+            runCommand(os.Args[1], cmd, os.Args[2:])
+            return
+        }
+    }
+
     var params gmapinfo.Params
     flag.BoolVar(&params.ShowDetails, "t", false, "show more technical details")
     flag.BoolVar(&params.ShowSubfiles, "s", false, "show subfiles details")
     flag.BoolVar(&params.Extract, "x", false, "extract subfiles")
     flag.BoolVar(&params.ZipOutput, "z", false, "pack extracted subfiles to zip file")
     flag.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flag.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
     flag.Usage = usage
     flag.Parse()
     argc := len(flag.Args())
@@ -44,4 +96,470 @@ func usage() {
     name := filepath.Base(os.Args[0])
     fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <img-file> [<output-file>]\n", name)
     flag.PrintDefaults()
+
+    names := make([]string, 0, len(commands))
+    for cmdname := range commands {
+        names = append(names, cmdname)
+    }
+    sort.Strings(names)
+
+    fmt.Fprintf(flag.CommandLine.Output(), "\nCommands:\n")
+    for _, cmdname := range names {
+        fmt.Fprintf(flag.CommandLine.Output(), "  %s %s %s\n", name, cmdname, commands[cmdname].args)
+    }
+}
+
+func runCommand(name string, cmd *command, args []string) {
+    flags := flag.NewFlagSet(name, flag.ExitOnError)
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n", filepath.Base(os.Args[0]), name, cmd.args)
+        flags.PrintDefaults()
+    }
+
+    err := cmd.run(flags, args)
+    if err == errBadArguments {
+        os.Stdout.Sync()
+        fmt.Fprintln(os.Stderr, "Bad arguments")
+        flags.Usage()
+        os.Exit(2)
+    }
+    if err != nil {
+        os.Stdout.Sync()
+        fmt.Fprintln(os.Stderr, "Error:", err.Error())
+        os.Exit(1)
+    }
+}
+
+func runLocate(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.LocateParams
+    flags.StringVar(&params.GpxFile, "gpx", "", "locate all points of GPX `file`")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    argc := flags.NArg()
+    if params.GpxFile != "" {
+        // Points come either from GPX file or from command line, not both
+        if argc != 1 {
+            return errBadArguments
+        }
+        params.FileName = flags.Arg(0)
+        return gmapinfo.Locate(params)
+    }
+    if argc != 3 {
+        return errBadArguments
+    }
+
+    params.FileName = flags.Arg(0)
+    point, err := parsePoint(flags.Arg(1), flags.Arg(2))
+    if err != nil {
+        return err
+    }
+    params.Point = point
+    return gmapinfo.Locate(params)
+}
+
+func runCoverage(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.CoverageParams
+    flags.StringVar(&params.Format, "format", "", "output `format`: kml or geojson (default: by output file extension)")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.Parse(args)
+
+    if flags.NArg() < 2 {
+        return errBadArguments
+    }
+    params.OutputName = flags.Arg(0)
+    params.FileNames = flags.Args()[1:]
+    return gmapinfo.Coverage(params)
+}
+
+func runPlaces(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.PlacesParams
+    flags.BoolVar(&params.Merge, "m", false, "merge places of all tiles into single list")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    return gmapinfo.Places(params)
+}
+
+func runPois(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.PoisParams
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.StringVar(&params.TypeNames, "typenames", "", "name object types using `file` (text or TYP, default: map TYP and built-in names)")
+    flags.Parse(args)
+
+    argc := flags.NArg()
+    if argc < 1 || argc > 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+    return gmapinfo.Pois(params)
+}
+
+func runGrep(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.GrepParams
+    flags.BoolVar(&params.IgnoreCase, "i", false, "ignore case")
+    flags.BoolVar(&params.Regexp, "e", false, "pattern is a regular expression")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.Pattern = flags.Arg(1)
+    return gmapinfo.Grep(params)
+}
+
+func runServeTiles(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.ServeTilesParams
+    flags.StringVar(&params.Listen, "listen", "localhost:8080", "listen on `address`")
+    flags.IntVar(&params.CacheSize, "cache", 1024, "keep up to `n` rendered tiles in memory")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() < 1 {
+        return errBadArguments
+    }
+    params.FileNames = flags.Args()
+    return gmapinfo.ServeTiles(params)
+}
+
+func runSrt(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.SrtParams
+    flags.StringVar(&params.SortFile, "sort", "", "sort lines of text `file` using map collation")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    return gmapinfo.Srt(params)
+}
+
+func runTypes(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.TypesParams
+    flags.StringVar(&params.TypeNames, "typenames", "", "list also names of `file` (text or TYP)")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() > 1 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    return gmapinfo.Types(params)
+}
+
+func runStreets(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.StreetsParams
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    argc := flags.NArg()
+    if argc < 1 || argc > 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+    return gmapinfo.Streets(params)
+}
+
+func runRoads(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.RoadsParams
+    flags.StringVar(&params.TileName, "tile", "", "list only tile with given `name`")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    argc := flags.NArg()
+    if argc < 1 || argc > 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+    return gmapinfo.Roads(params)
+}
+
+func runStatsRouting(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.StatsRoutingParams
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    argc := flags.NArg()
+    if argc < 1 || argc > 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+    return gmapinfo.StatsRouting(params)
+}
+
+func runStatsTypes(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.StatsParams
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.StringVar(&params.TypeNames, "typenames", "", "name object types using `file` (text or TYP, default: map TYP and built-in names)")
+    flags.Parse(args)
+
+    argc := flags.NArg()
+    if argc < 1 || argc > 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+    return gmapinfo.StatsTypes(params)
+}
+
+func runExportGeoJSON(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.ExportGeoJSONParams
+    level := flags.String("level", "0", "export objects of level with given `zoom`, or of all levels if \"all\"")
+    bbox := flags.String("bbox", "", "export only objects intersecting `south,west,north,east` box")
+    flags.BoolVar(&params.Split, "split", false, "write one file per tile into output directory")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.StringVar(&params.TypeNames, "typenames", "", "name object types using `file` (text or TYP, default: map TYP and built-in names)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+
+    var err error
+    params.Zoom, err = parseLevel(*level)
+    if err != nil {
+        return err
+    }
+    params.BBox, err = parseBBox(*bbox)
+    if err != nil {
+        return err
+    }
+    return gmapinfo.ExportGeoJSON(params)
+}
+
+func runExportShapefile(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.ExportShapefileParams
+    level := flags.String("level", "0", "export objects of level with given `zoom`, or of all levels if \"all\"")
+    bbox := flags.String("bbox", "", "export only objects intersecting `south,west,north,east` box")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.StringVar(&params.TypeNames, "typenames", "", "name object types using `file` (text or TYP, default: map TYP and built-in names)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+
+    var err error
+    params.Zoom, err = parseLevel(*level)
+    if err != nil {
+        return err
+    }
+    params.BBox, err = parseBBox(*bbox)
+    if err != nil {
+        return err
+    }
+    return gmapinfo.ExportShapefile(params)
+}
+
+func runExportGpx(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.ExportGpxParams
+    flags.StringVar(&params.Types, "types", "", "export only POIs of comma separated type `ranges`, like 0x2A00-0x2AFF,0x2F01")
+    bbox := flags.String("bbox", "", "export only POIs inside `south,west,north,east` box")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+
+    var err error
+    params.BBox, err = parseBBox(*bbox)
+    if err != nil {
+        return err
+    }
+    return gmapinfo.ExportGpx(params)
+}
+
+func runExportMp(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.ExportMpParams
+    flags.StringVar(&params.TileName, "tile", "", "export only tile with given `name` to output file")
+    bbox := flags.String("bbox", "", "export only objects intersecting `south,west,north,east` box")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+
+    var err error
+    params.BBox, err = parseBBox(*bbox)
+    if err != nil {
+        return err
+    }
+    return gmapinfo.ExportMp(params)
+}
+
+func runExportOsm(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.ExportOsmParams
+    level := flags.String("level", "0", "export objects of level with given `zoom`, or of all levels if \"all\"")
+    bbox := flags.String("bbox", "", "export only objects intersecting `south,west,north,east` box")
+    flags.StringVar(&params.TagsFile, "tags", "", "map type codes to OSM tags using mapping `file`")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+
+    var err error
+    params.Zoom, err = parseLevel(*level)
+    if err != nil {
+        return err
+    }
+    params.BBox, err = parseBBox(*bbox)
+    if err != nil {
+        return err
+    }
+    return gmapinfo.ExportOsm(params)
+}
+
+func runExportPmtiles(flags *flag.FlagSet, args []string) error {
+    var params gmapinfo.ExportPmtilesParams
+    flags.IntVar(&params.MinZoom, "minzoom", 0, "generate tiles from `zoom` level")
+    flags.IntVar(&params.MaxZoom, "maxzoom", 14, "generate tiles up to `zoom` level")
+    bbox := flags.String("bbox", "", "export only objects intersecting `south,west,north,east` box")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.StringVar(&params.TypeNames, "typenames", "", "name object types using `file` (text or TYP, default: map TYP and built-in names)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+
+    var err error
+    params.BBox, err = parseBBox(*bbox)
+    if err != nil {
+        return err
+    }
+    return gmapinfo.ExportPmtiles(params)
+}
+
+func runRenderPng(flags *flag.FlagSet, args []string) error {
+    return runRender(flags, args, gmapinfo.RenderPng)
+}
+
+func runRenderSvg(flags *flag.FlagSet, args []string) error {
+    return runRender(flags, args, gmapinfo.RenderSvg)
+}
+
+// Renderers of all image formats take the same flags
+func runRender(flags *flag.FlagSet, args []string, render func(gmapinfo.RenderParams) error) error {
+    var params gmapinfo.RenderParams
+    level := flags.String("level", "0", "render objects of level with given `zoom`, or of all levels if \"all\"")
+    bbox := flags.String("bbox", "", "render `south,west,north,east` box (default: bounds of all objects)")
+    size := flags.String("size", "1024", "image size in pixels, `width` or widthxheight")
+    flags.BoolVar(&params.ForceOverwrite, "f", false, "overwrite existing files if necessary")
+    flags.IntVar(&params.Codepage, "cp", 0, "convert map texts from `codepage` (default: declared by map)")
+    flags.StringVar(&params.TypeNames, "typenames", "", "name object types using `file` (text or TYP, default: map TYP and built-in names)")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errBadArguments
+    }
+    params.FileName = flags.Arg(0)
+    params.OutputName = flags.Arg(1)
+
+    var err error
+    params.Zoom, err = parseLevel(*level)
+    if err != nil {
+        return err
+    }
+    params.BBox, err = parseBBox(*bbox)
+    if err != nil {
+        return err
+    }
+    params.Width, params.Height, err = parseSize(*size)
+    if err != nil {
+        return err
+    }
+    return render(params)
+}
+
+// Parses zoom of map level or "all"
+func parseLevel(s string) (int, error) {
+    if s == "all" {
+        return gmapinfo.AllLevels, nil
+    }
+    zoom, err := strconv.Atoi(s)
+    if err != nil || zoom < 0 || zoom > 15 {
+        return 0, errBadArguments
+    }
+    return zoom, nil
+}
+
+// Parses bounding box, empty string means no box
+func parseBBox(s string) (*coords.Rect, error) {
+    if s == "" {
+        return nil, nil
+    }
+    r, err := coords.ParseRect(s)
+    if err != nil {
+        return nil, err
+    }
+    return &r, nil
+}
+
+// Parses image size given as width or widthxheight, height is zero if not given
+func parseSize(s string) (int, int, error) {
+    parts := strings.Split(s, "x")
+    if len(parts) > 2 {
+        return 0, 0, errBadArguments
+    }
+    var size [2]int
+    for i, part := range parts {
+        v, err := strconv.Atoi(part)
+        if err != nil || v < 1 || v > 16384 {
+            return 0, 0, errBadArguments
+        }
+        size[i] = v
+    }
+    return size[0], size[1], nil
+}
+
+func parsePoint(lat, lon string) (coords.Point, error) {
+    var p coords.Point
+    var err error
+    p.Lat, err = strconv.ParseFloat(lat, 64)
+    if err != nil || p.Lat < -90 || p.Lat > 90 {
+        return p, errBadArguments
+    }
+    p.Lon, err = strconv.ParseFloat(lon, 64)
+    if err != nil || p.Lon < -180 || p.Lon > 180 {
+        return p, errBadArguments
+    }
+    return p, nil
 }